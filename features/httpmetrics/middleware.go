@@ -0,0 +1,198 @@
+// Package httpmetrics instruments an http.Handler with request metrics and
+// route-level latency histograms, emitted through services.MetricRegistry so
+// they show up in the PM2.io dashboard without any manual increments.
+package httpmetrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/keymetrics/pm2-io-apm-go/services"
+)
+
+const defaultCardinalityCap = 200
+
+type routeKey struct{}
+
+// Config tunes what Middleware observes.
+type Config struct {
+	// CardinalityCap bounds the number of distinct raw paths tracked before
+	// further unmatched paths collapse into a single "other" bucket. Only
+	// applies when no route pattern is available (see WrapMux/GorillaMiddleware).
+	// Defaults to 200.
+	CardinalityCap int
+
+	// Buckets are the latency histogram bucket bounds, in milliseconds.
+	Buckets []float64
+}
+
+func (c Config) withDefaults() Config {
+	if c.CardinalityCap <= 0 {
+		c.CardinalityCap = defaultCardinalityCap
+	}
+	if len(c.Buckets) == 0 {
+		c.Buckets = []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000}
+	}
+	return c
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+// newStatusRecorder wraps w, defaulting status to 200 since net/http reports
+// that status for a handler that never calls WriteHeader/Write explicitly.
+func newStatusRecorder(w http.ResponseWriter) *statusRecorder {
+	return &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+type collector struct {
+	config    Config
+	total     *services.Counter
+	inFlight  *services.Gauge
+	respBytes *services.Counter
+
+	mu        sync.Mutex
+	rawRoutes map[string]bool
+	latencies map[string]*services.Histogram
+}
+
+func newCollector(config Config) *collector {
+	return &collector{
+		config:    config.withDefaults(),
+		total:     services.NewCounter("http_requests_total", nil),
+		inFlight:  services.NewGauge("http_requests_in_flight", nil),
+		respBytes: services.NewCounter("http_response_bytes_total", nil),
+		rawRoutes: make(map[string]bool),
+		latencies: make(map[string]*services.Histogram),
+	}
+}
+
+func (c *collector) observe(r *http.Request, status int, bytes int64, elapsedMs float64) {
+	c.total.Inc()
+	c.respBytes.Add(bytes)
+
+	route := c.routeFor(r)
+	c.latencyFor(route, status).Observe(elapsedMs)
+}
+
+// routeFor returns the route pattern stashed in context by WrapMux or
+// GorillaMiddleware, falling back to the raw path capped at CardinalityCap
+// distinct values so an attacker-controlled path can't blow up the metric set.
+func (c *collector) routeFor(r *http.Request) string {
+	if pattern, ok := r.Context().Value(routeKey{}).(string); ok && pattern != "" {
+		return pattern
+	}
+	return c.capPath(r.URL.Path)
+}
+
+func (c *collector) capPath(path string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.rawRoutes[path] {
+		return path
+	}
+	if len(c.rawRoutes) >= c.config.CardinalityCap {
+		return "other"
+	}
+	c.rawRoutes[path] = true
+	return path
+}
+
+func (c *collector) latencyFor(route string, status int) *services.Histogram {
+	key := route + ":" + strconv.Itoa(status)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if h, ok := c.latencies[key]; ok {
+		return h
+	}
+	h := services.NewHistogram("http_request_duration_ms", map[string]string{
+		"route":  route,
+		"status": strconv.Itoa(status),
+	}, c.config.Buckets)
+	c.latencies[key] = h
+	return h
+}
+
+// Middleware wraps handler with total/in-flight request counters, a response
+// byte counter and a per-route, per-status latency histogram. The matched
+// route pattern is read from context when the request passed through WrapMux
+// or GorillaMiddleware first; otherwise the raw path is used, capped to avoid
+// unbounded cardinality.
+func Middleware(handler http.Handler) http.Handler {
+	return MiddlewareWithConfig(handler, Config{})
+}
+
+// MiddlewareWithConfig is like Middleware but allows tuning Config.
+func MiddlewareWithConfig(handler http.Handler, config Config) http.Handler {
+	c := newCollector(config)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.inFlight.Inc()
+		defer c.inFlight.Dec()
+
+		recorder := newStatusRecorder(w)
+		start := time.Now()
+		handler.ServeHTTP(recorder, r)
+		elapsedMs := float64(time.Since(start)) / float64(time.Millisecond)
+
+		c.observe(r, recorder.status, recorder.bytes, elapsedMs)
+	})
+}
+
+// WrapMux wraps mux so the pattern used to look the handler up (e.g.
+// "/users/") is available to Middleware instead of falling back to the raw
+// path. Register Middleware around the returned handler.
+func WrapMux(serveMux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := serveMux.Handler(r)
+		ctx := context.WithValue(r.Context(), routeKey{}, pattern)
+		serveMux.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GorillaMiddleware returns a mux.MiddlewareFunc that stashes the matched
+// route's path template into context, for use with a *mux.Router. Register it
+// with router.Use(httpmetrics.GorillaMiddleware(router)) before wrapping the
+// router itself with Middleware.
+func GorillaMiddleware(router *mux.Router) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pattern := r.URL.Path
+			if route := mux.CurrentRoute(r); route != nil {
+				if tpl, err := route.GetPathTemplate(); err == nil {
+					pattern = tpl
+				}
+			}
+			ctx := context.WithValue(r.Context(), routeKey{}, pattern)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package httpmetrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/keymetrics/pm2-io-apm-go/services"
+)
+
+func TestStatusRecorderDefaultsToOKWhenHandlerNeverWrites(t *testing.T) {
+	rec := newStatusRecorder(httptest.NewRecorder())
+	if rec.status != http.StatusOK {
+		t.Fatalf("status = %d, want %d before any write", rec.status, http.StatusOK)
+	}
+}
+
+func TestStatusRecorderCapturesExplicitStatus(t *testing.T) {
+	rec := newStatusRecorder(httptest.NewRecorder())
+	rec.WriteHeader(http.StatusNotFound)
+	if rec.status != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", rec.status, http.StatusNotFound)
+	}
+}
+
+func TestStatusRecorderWriteImpliesOK(t *testing.T) {
+	rec := newStatusRecorder(httptest.NewRecorder())
+	n, err := rec.Write([]byte("hello"))
+	if err != nil {
+		t.Fatalf("Write() err = %v", err)
+	}
+	if n != 5 {
+		t.Fatalf("Write() n = %d, want 5", n)
+	}
+	if rec.status != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.status, http.StatusOK)
+	}
+	if rec.bytes != 5 {
+		t.Fatalf("bytes = %d, want 5", rec.bytes)
+	}
+}
+
+func TestStatusRecorderFirstWriteHeaderWins(t *testing.T) {
+	rec := newStatusRecorder(httptest.NewRecorder())
+	rec.WriteHeader(http.StatusCreated)
+	rec.WriteHeader(http.StatusInternalServerError)
+	if rec.status != http.StatusCreated {
+		t.Fatalf("status = %d, want %d (first WriteHeader call should win)", rec.status, http.StatusCreated)
+	}
+}
+
+func TestCapPathUnderLimitReturnsRawPath(t *testing.T) {
+	c := newCollector(Config{CardinalityCap: 3})
+	if got := c.capPath("/users/1"); got != "/users/1" {
+		t.Fatalf("capPath() = %q, want %q", got, "/users/1")
+	}
+	if got := c.capPath("/users/1"); got != "/users/1" {
+		t.Fatalf("capPath() on repeat = %q, want %q", got, "/users/1")
+	}
+}
+
+func TestCapPathOverLimitCollapsesToOther(t *testing.T) {
+	c := newCollector(Config{CardinalityCap: 2})
+	c.capPath("/a")
+	c.capPath("/b")
+
+	if got := c.capPath("/c"); got != "other" {
+		t.Fatalf("capPath() over cap = %q, want %q", got, "other")
+	}
+	// Paths already tracked before the cap was hit keep resolving to themselves.
+	if got := c.capPath("/a"); got != "/a" {
+		t.Fatalf("capPath() for already-tracked path = %q, want %q", got, "/a")
+	}
+}
+
+func TestMiddlewareRecordsStatusForNoWriteHandler(t *testing.T) {
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Intentionally never calls WriteHeader or Write.
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("recorder status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	name := services.WithLabels("http_request_duration_ms", map[string]string{"route": "/ping", "status": "200"})
+	if _, ok := services.GetMetricsAsMap()[name]; !ok {
+		t.Fatalf("no latency histogram %s registered, want the request recorded with status 200", name)
+	}
+}
@@ -0,0 +1,237 @@
+// Package recovery recovers panics from HTTP handlers and goroutines and
+// forwards them to an error notifier, deduplicating repeats of the same
+// panic within a configurable window so a hot error path doesn't flood it.
+package recovery
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWindow   = 60 * time.Second
+	stackFrameDepth = 5
+)
+
+// ErrorNotifier is the subset of the notifier's API this package depends on,
+// so it can forward captured panics without importing the root package
+// (which itself depends on services/features and would create an import
+// cycle). Pm2Io.Notifier already satisfies this interface.
+type ErrorNotifier interface {
+	Error(err error)
+}
+
+// snapshotError is an immutable copy of a group's message and occurrence
+// count at the moment it was sent, so a notifier that queues or batches
+// errors instead of stringifying them synchronously can't see the count
+// change out from under an already-sent notification.
+type snapshotError struct {
+	message string
+}
+
+func (s snapshotError) Error() string { return s.message }
+
+// group tracks one deduplicated panic: the underlying error, its stack (kept
+// for the one-time log line emitted on first occurrence), how many times it
+// has occurred since the group was created (count), and what count was when
+// it was last sent to the notifier (flushed).
+type group struct {
+	mu       sync.Mutex
+	err      error
+	stack    string
+	count    int
+	flushed  int
+	lastSeen time.Time
+}
+
+// snapshot returns an immutable error reflecting count at the time it's
+// called, suitable for handing to the notifier.
+func (g *group) snapshot() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.count <= 1 {
+		return snapshotError{message: g.err.Error()}
+	}
+	return snapshotError{message: fmt.Sprintf("%s (x%d since first seen)", g.err.Error(), g.count)}
+}
+
+// Recovery recovers panics and forwards them to an ErrorNotifier. The first
+// occurrence of a given panic is sent immediately; repeats of the same panic
+// (same file:line, error type and top stack frames) within the window only
+// increment a counter, flushed as a single aggregated event per window.
+type Recovery struct {
+	notifier ErrorNotifier
+	window   time.Duration
+
+	mu     sync.Mutex
+	groups map[string]*group
+
+	stop chan struct{}
+}
+
+// New returns a Recovery forwarding panics to notifier, deduplicating
+// repeats within window. window <= 0 uses the default of 60s.
+func New(notifier ErrorNotifier, window time.Duration) *Recovery {
+	if window <= 0 {
+		window = defaultWindow
+	}
+	r := &Recovery{
+		notifier: notifier,
+		window:   window,
+		groups:   make(map[string]*group),
+		stop:     make(chan struct{}),
+	}
+	go r.flushLoop()
+	return r
+}
+
+// Close stops the periodic flush loop.
+func (r *Recovery) Close() {
+	close(r.stop)
+}
+
+// Middleware recovers a panic in the wrapped handler, forwards it through
+// Recovery's deduplication, and replies with 500 if no response was sent yet.
+func (r *Recovery) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				r.report(toError(rec))
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(w, req)
+	})
+}
+
+// Go runs fn in a new goroutine, recovering any panic and forwarding it
+// through Recovery's deduplication instead of crashing the process.
+func (r *Recovery) Go(fn func()) {
+	go func() {
+		defer func() {
+			if rec := recover(); rec != nil {
+				r.report(toError(rec))
+			}
+		}()
+		fn()
+	}()
+}
+
+func toError(rec interface{}) error {
+	if err, ok := rec.(error); ok {
+		return err
+	}
+	return fmt.Errorf("%v", rec)
+}
+
+// report either sends err immediately (first time this group is seen) or
+// bumps its occurrence count for the next periodic flush.
+func (r *Recovery) report(err error) {
+	pcs := capturePCs(3)
+	key := groupKey(err, pcs)
+
+	r.mu.Lock()
+	g, ok := r.groups[key]
+	if !ok {
+		g = &group{err: err, stack: formatStack(pcs), count: 1, flushed: 1, lastSeen: time.Now()}
+		r.groups[key] = g
+	} else {
+		g.mu.Lock()
+		g.count++
+		g.lastSeen = time.Now()
+		g.mu.Unlock()
+	}
+	r.mu.Unlock()
+
+	if !ok {
+		log.Printf("recovery: panic recovered: %s\n%s", err.Error(), g.stack)
+		r.notifier.Error(g.snapshot())
+	}
+}
+
+func (r *Recovery) flushLoop() {
+	ticker := time.NewTicker(r.window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.flush()
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// flush sends an aggregated event for any group that recurred since the last
+// flush, and drops groups that haven't recurred in a full window - keeping a
+// still-recurring panic's group alive so it keeps being aggregated instead of
+// restarting as a "first occurrence" every window.
+func (r *Recovery) flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for key, g := range r.groups {
+		g.mu.Lock()
+		count := g.count
+		prevFlushed := g.flushed
+		quiet := now.Sub(g.lastSeen) > r.window
+		g.flushed = count
+		g.mu.Unlock()
+
+		if count > prevFlushed {
+			r.notifier.Error(g.snapshot())
+		}
+		if quiet {
+			delete(r.groups, key)
+		}
+	}
+}
+
+// capturePCs grabs up to stackFrameDepth program counters above the caller of
+// capturePCs's caller, i.e. where the panic was recovered.
+func capturePCs(skip int) []uintptr {
+	pcs := make([]uintptr, stackFrameDepth)
+	n := runtime.Callers(skip, pcs)
+	return pcs[:n]
+}
+
+// groupKey hashes (file:line of the first frame, the error's type, and the
+// top stackFrameDepth frames) so recurrences of the same panic collapse into
+// one group regardless of the error message's contents.
+func groupKey(err error, pcs []uintptr) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%T", err)
+
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&b, "|%s:%d", frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(b.String()))
+	return fmt.Sprintf("%x", h.Sum64())
+}
+
+func formatStack(pcs []uintptr) string {
+	var b strings.Builder
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&b, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,86 @@
+package recovery
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNotifier records every error handed to it, for assertions.
+type fakeNotifier struct {
+	mu     sync.Mutex
+	errors []error
+}
+
+func (f *fakeNotifier) Error(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errors = append(f.errors, err)
+}
+
+func (f *fakeNotifier) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.errors)
+}
+
+func TestRecoveryReportsFirstOccurrenceImmediately(t *testing.T) {
+	notifier := &fakeNotifier{}
+	r := New(notifier, time.Hour)
+	defer r.Close()
+
+	r.report(errors.New("boom"))
+
+	if got := notifier.count(); got != 1 {
+		t.Fatalf("notifier received %d errors, want 1", got)
+	}
+}
+
+func TestRecoveryDeduplicatesWithinWindowAndFlushesOnce(t *testing.T) {
+	notifier := &fakeNotifier{}
+	window := 30 * time.Millisecond
+	r := New(notifier, window)
+	defer r.Close()
+
+	// First occurrence sent immediately; three more recurrences within the
+	// window should only be aggregated, not sent again until flush.
+	for i := 0; i < 4; i++ {
+		r.report(errors.New("boom"))
+	}
+	if got := notifier.count(); got != 1 {
+		t.Fatalf("notifier received %d errors before flush, want 1", got)
+	}
+
+	time.Sleep(2 * window)
+
+	if got := notifier.count(); got != 2 {
+		t.Fatalf("notifier received %d errors after flush, want 2 (first occurrence + one aggregate)", got)
+	}
+}
+
+func TestRecoveryReportsRecurrenceAfterEachQuietWindow(t *testing.T) {
+	notifier := &fakeNotifier{}
+	window := 20 * time.Millisecond
+	r := New(notifier, window)
+	defer r.Close()
+
+	r.report(errors.New("boom"))
+	if got := notifier.count(); got != 1 {
+		t.Fatalf("notifier received %d errors, want 1", got)
+	}
+
+	// A single recurrence every window and a half must keep being reported on
+	// the following flush, not just the first one - this is the off-by-one the
+	// flushed/prevFlushed bookkeeping guards against.
+	for i := 0; i < 3; i++ {
+		time.Sleep(window + window/2)
+		r.report(errors.New("boom"))
+	}
+
+	time.Sleep(2 * window)
+
+	if got := notifier.count(); got < 3 {
+		t.Fatalf("notifier received %d errors, want at least 3 (one per recurrence)", got)
+	}
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/keymetrics/pm2-io-apm-go/structures"
+)
+
+var (
+	actionsMu sync.RWMutex
+	actions   = map[string]*structures.Action{}
+)
+
+// AddAction registers action so it appears in the keymetrics dashboard and
+// can be triggered over the trigger:action WS channel.
+func AddAction(action *structures.Action) {
+	actionsMu.Lock()
+	defer actionsMu.Unlock()
+	actions[action.ActionName] = action
+}
+
+// ActionError is the error CallAction returns when the action is unknown, its
+// parameters fail validation, or its TypedCallback panics; its Message is what
+// trigger:action:failure reports. An error returned by the TypedCallback
+// itself is passed through as is.
+type ActionError struct {
+	Message string `json:"message"`
+}
+
+func (e *ActionError) Error() string { return e.Message }
+
+// CallActionResult is what CallAction returns: Value on success (the legacy
+// Callback's string, or nil for an action with no callback), or Err
+// describing why the action failed.
+type CallActionResult struct {
+	Value interface{}
+	Err   error
+}
+
+// CallAction dispatches the action named name. When it has a TypedCallback,
+// params are validated against its Parameters schema first, and a panic
+// inside the callback is recovered into Err rather than crashing the message
+// handler. Otherwise it falls back to the legacy, parameter-less Callback.
+func CallAction(name string, payload map[string]interface{}) CallActionResult {
+	actionsMu.RLock()
+	action, ok := actions[name]
+	actionsMu.RUnlock()
+	if !ok {
+		return CallActionResult{Err: &ActionError{Message: fmt.Sprintf("unknown action %q", name)}}
+	}
+
+	if action.TypedCallback != nil {
+		params, _ := payload["args"].(map[string]interface{})
+		return callTyped(action, params)
+	}
+	if action.Callback != nil {
+		return CallActionResult{Value: action.Callback()}
+	}
+	return CallActionResult{}
+}
+
+func callTyped(action *structures.Action, params map[string]interface{}) (result CallActionResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = CallActionResult{Err: &ActionError{Message: fmt.Sprintf("action %q panicked: %v", action.ActionName, r)}}
+		}
+	}()
+
+	params = applyDefaults(action.Parameters, params)
+
+	if err := ValidateParams(action.Parameters, params); err != nil {
+		return CallActionResult{Err: err}
+	}
+
+	value, err := action.TypedCallback(context.Background(), params)
+	if err != nil {
+		return CallActionResult{Err: err}
+	}
+	return CallActionResult{Value: value}
+}
+
+// applyDefaults fills in schema's declared Default for any optional
+// parameter missing from params, so a TypedCallback sees its advertised
+// default instead of the parameter being silently absent.
+func applyDefaults(schema []structures.ActionParam, params map[string]interface{}) map[string]interface{} {
+	if params == nil {
+		params = make(map[string]interface{})
+	}
+	for _, p := range schema {
+		if p.Default == nil {
+			continue
+		}
+		if _, ok := params[p.Name]; !ok {
+			params[p.Name] = p.Default
+		}
+	}
+	return params
+}
+
+// ValidateParams checks params against schema, returning an *ActionError
+// describing the first problem found: a required parameter missing, or a
+// parameter whose value doesn't match its declared type.
+func ValidateParams(schema []structures.ActionParam, params map[string]interface{}) error {
+	for _, p := range schema {
+		value, ok := params[p.Name]
+		if !ok {
+			if p.Required {
+				return &ActionError{Message: fmt.Sprintf("missing required parameter %q", p.Name)}
+			}
+			continue
+		}
+		if !matchesParamType(value, p.Type) {
+			return &ActionError{Message: fmt.Sprintf("parameter %q expects type %q", p.Name, p.Type)}
+		}
+	}
+	return nil
+}
+
+func matchesParamType(value interface{}, kind string) bool {
+	switch kind {
+	case "", "any":
+		return true
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		switch value.(type) {
+		case float64, int, int64:
+			return true
+		default:
+			return false
+		}
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	default:
+		return true
+	}
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/keymetrics/pm2-io-apm-go/structures"
+)
+
+func TestCallActionMissingRequiredParam(t *testing.T) {
+	AddAction(&structures.Action{
+		ActionName: "test-missing-required",
+		Parameters: []structures.ActionParam{
+			{Name: "count", Type: "number", Required: true},
+		},
+		TypedCallback: func(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+			return "should not run", nil
+		},
+	})
+
+	result := CallAction("test-missing-required", map[string]interface{}{"args": map[string]interface{}{}})
+	if result.Err == nil {
+		t.Fatal("CallAction() err = nil, want an error for the missing required parameter")
+	}
+}
+
+func TestCallActionWrongType(t *testing.T) {
+	AddAction(&structures.Action{
+		ActionName: "test-wrong-type",
+		Parameters: []structures.ActionParam{
+			{Name: "count", Type: "number", Required: true},
+		},
+		TypedCallback: func(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+			return "should not run", nil
+		},
+	})
+
+	result := CallAction("test-wrong-type", map[string]interface{}{
+		"args": map[string]interface{}{"count": "not a number"},
+	})
+	if result.Err == nil {
+		t.Fatal("CallAction() err = nil, want an error for the wrong-typed parameter")
+	}
+}
+
+func TestCallActionAppliesDefaultForMissingOptionalParam(t *testing.T) {
+	var seen interface{}
+	AddAction(&structures.Action{
+		ActionName: "test-default",
+		Parameters: []structures.ActionParam{
+			{Name: "level", Type: "string", Required: false, Default: "info"},
+		},
+		TypedCallback: func(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+			seen = params["level"]
+			return "ok", nil
+		},
+	})
+
+	result := CallAction("test-default", map[string]interface{}{"args": map[string]interface{}{}})
+	if result.Err != nil {
+		t.Fatalf("CallAction() err = %v, want nil", result.Err)
+	}
+	if seen != "info" {
+		t.Fatalf("params[\"level\"] = %v, want the declared default %q", seen, "info")
+	}
+}
+
+func TestCallActionExplicitValueOverridesDefault(t *testing.T) {
+	var seen interface{}
+	AddAction(&structures.Action{
+		ActionName: "test-default-override",
+		Parameters: []structures.ActionParam{
+			{Name: "level", Type: "string", Required: false, Default: "info"},
+		},
+		TypedCallback: func(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+			seen = params["level"]
+			return "ok", nil
+		},
+	})
+
+	result := CallAction("test-default-override", map[string]interface{}{
+		"args": map[string]interface{}{"level": "debug"},
+	})
+	if result.Err != nil {
+		t.Fatalf("CallAction() err = %v, want nil", result.Err)
+	}
+	if seen != "debug" {
+		t.Fatalf("params[\"level\"] = %v, want the explicit value %q", seen, "debug")
+	}
+}
+
+func TestCallActionRecoversPanic(t *testing.T) {
+	AddAction(&structures.Action{
+		ActionName: "test-panic",
+		TypedCallback: func(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+			panic("boom")
+		},
+	})
+
+	result := CallAction("test-panic", map[string]interface{}{"args": map[string]interface{}{}})
+	if result.Err == nil {
+		t.Fatal("CallAction() err = nil, want the recovered panic surfaced as an error")
+	}
+}
+
+func TestCallActionUnknownName(t *testing.T) {
+	result := CallAction("does-not-exist", nil)
+	if result.Err == nil {
+		t.Fatal("CallAction() err = nil, want an error for an unregistered action")
+	}
+}
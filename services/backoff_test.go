@@ -0,0 +1,42 @@
+package services
+
+import "testing"
+
+func TestNextBackoffStartsAtMin(t *testing.T) {
+	wait, next := nextBackoff(0)
+	if wait != minBackoff {
+		t.Errorf("wait = %v, want %v", wait, minBackoff)
+	}
+	if next != minBackoff*2 {
+		t.Errorf("next = %v, want %v", next, minBackoff*2)
+	}
+}
+
+func TestNextBackoffDoublesAndCaps(t *testing.T) {
+	current := minBackoff
+	for current < maxBackoff {
+		wait, next := nextBackoff(current)
+		if wait != current {
+			t.Errorf("wait = %v, want %v", wait, current)
+		}
+		if next > maxBackoff {
+			t.Errorf("next = %v, exceeds maxBackoff %v", next, maxBackoff)
+		}
+		current = next
+	}
+
+	wait, next := nextBackoff(maxBackoff)
+	if wait != maxBackoff || next != maxBackoff {
+		t.Errorf("nextBackoff(maxBackoff) = (%v, %v), want (%v, %v)", wait, next, maxBackoff, maxBackoff)
+	}
+}
+
+func TestJitteredDelayWithinBounds(t *testing.T) {
+	wait := 10 * minBackoff
+	for i := 0; i < 100; i++ {
+		delay := jitteredDelay(wait)
+		if delay < wait/2 || delay > wait {
+			t.Fatalf("jitteredDelay(%v) = %v, want within [%v, %v]", wait, delay, wait/2, wait)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel is the severity of a Logger entry, ordered from most to least verbose.
+type LogLevel int
+
+const (
+	LogTrace LogLevel = iota
+	LogDebug
+	LogInfo
+	LogWarn
+	LogError
+	LogFatal
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogTrace:
+		return "trace"
+	case LogDebug:
+		return "debug"
+	case LogInfo:
+		return "info"
+	case LogWarn:
+		return "warn"
+	case LogError:
+		return "error"
+	case LogFatal:
+		return "fatal"
+	default:
+		return "unknown"
+	}
+}
+
+// LogEntry is a single record produced by a Logger, ready to be streamed or persisted.
+type LogEntry struct {
+	Level   LogLevel  `json:"level"`
+	Message string    `json:"message"`
+	At      time.Time `json:"at"`
+}
+
+// LogSink receives entries that passed the Logger's level and rate-limit filters.
+// Implement it to plug a backend in, e.g. stdout, a rotating file or the WS transport.
+type LogSink interface {
+	WriteLog(entry LogEntry)
+}
+
+type rateLimiter struct {
+	mu       sync.Mutex
+	limit    int
+	window   time.Duration
+	count    int
+	resetsAt time.Time
+}
+
+func (r *rateLimiter) allow() bool {
+	if r.limit <= 0 {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if now.After(r.resetsAt) {
+		r.count = 0
+		r.resetsAt = now.Add(r.window)
+	}
+	if r.count >= r.limit {
+		return false
+	}
+	r.count++
+	return true
+}
+
+// Logger is a leveled, pluggable logging subsystem. It fans entries out to any
+// number of LogSink backends and keeps the most recent accepted entries in a
+// small ring buffer, drained by Flush. Only entries written while the logger
+// is started are buffered, and Start clears the buffer, so nothing from before
+// the latest Start is kept.
+type Logger struct {
+	mu       sync.RWMutex
+	minLevel LogLevel
+	sinks    []LogSink
+	limiters map[LogLevel]*rateLimiter
+	buffer   []LogEntry
+	bufSize  int
+	enabled  bool
+}
+
+// NewLogger returns a Logger filtering below LogInfo until SetLevel is called,
+// disabled until Start is invoked (mirroring the pm2 startLogging/stopLogging actions).
+func NewLogger() *Logger {
+	return &Logger{
+		minLevel: LogInfo,
+		limiters: make(map[LogLevel]*rateLimiter),
+		bufSize:  200,
+	}
+}
+
+func (l *Logger) SetLevel(level LogLevel) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.minLevel = level
+}
+
+// RateLimit caps the number of entries accepted for level within window, dropping the rest.
+func (l *Logger) RateLimit(level LogLevel, limit int, window time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limiters[level] = &rateLimiter{limit: limit, window: window}
+}
+
+// AddSink registers a backend that every accepted entry is forwarded to.
+// Any io.Writer can be wrapped with WriterSink, which makes it easy to hook
+// up logrus/zap or anything else that writes through an io.Writer.
+func (l *Logger) AddSink(sink LogSink) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sinks = append(l.sinks, sink)
+}
+
+// Start enables dispatch and buffering. Called when the dashboard sends
+// startLogging. The buffer is cleared first, so entries already streamed
+// live during a previous enabled period aren't replayed by the next Flush.
+func (l *Logger) Start() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buffer = nil
+	l.enabled = true
+}
+
+// Stop disables dispatch. Called when the dashboard sends stopLogging.
+func (l *Logger) Stop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.enabled = false
+}
+
+func (l *Logger) write(level LogLevel, message string) {
+	l.mu.RLock()
+	if !l.enabled || level < l.minLevel {
+		l.mu.RUnlock()
+		return
+	}
+	limiter := l.limiters[level]
+	sinks := l.sinks
+	l.mu.RUnlock()
+
+	if limiter != nil && !limiter.allow() {
+		return
+	}
+
+	entry := LogEntry{Level: level, Message: message, At: time.Now()}
+
+	l.mu.Lock()
+	l.buffer = append(l.buffer, entry)
+	if len(l.buffer) > l.bufSize {
+		l.buffer = l.buffer[len(l.buffer)-l.bufSize:]
+	}
+	l.mu.Unlock()
+
+	for _, sink := range sinks {
+		sink.WriteLog(entry)
+	}
+}
+
+func (l *Logger) Trace(message string) { l.write(LogTrace, message) }
+func (l *Logger) Debug(message string) { l.write(LogDebug, message) }
+func (l *Logger) Info(message string)  { l.write(LogInfo, message) }
+func (l *Logger) Warn(message string)  { l.write(LogWarn, message) }
+func (l *Logger) Error(message string) { l.write(LogError, message) }
+func (l *Logger) Fatal(message string) { l.write(LogFatal, message) }
+
+// Flush drains and returns the buffered entries, for streaming to the keymetrics UI.
+func (l *Logger) Flush() []LogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	buffered := l.buffer
+	l.buffer = nil
+	return buffered
+}
+
+// Write implements io.Writer at LogInfo, so a Logger can be passed to logrus'
+// SetOutput, zap's AddSink, or any other package expecting a writer.
+func (l *Logger) Write(p []byte) (int, error) {
+	l.Info(strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
+
+// CaptureStandardLog redirects the standard library's log package output into logger.
+func CaptureStandardLog(logger *Logger) {
+	log.SetOutput(logger)
+}
+
+// StdoutSink prints entries to standard output.
+type StdoutSink struct{}
+
+func (StdoutSink) WriteLog(entry LogEntry) {
+	fmt.Printf("[%s] %s %s\n", entry.At.Format(time.RFC3339), entry.Level, entry.Message)
+}
+
+// WriterSink writes entries to any io.Writer, one formatted line per entry.
+type WriterSink struct {
+	Writer io.Writer
+}
+
+func (s WriterSink) WriteLog(entry LogEntry) {
+	fmt.Fprintf(s.Writer, "[%s] %s %s\n", entry.At.Format(time.RFC3339), entry.Level, entry.Message)
+}
+
+// FileSink appends entries to a file, rotating it once it reaches maxBytes and
+// keeping at most maxBackups rotated copies alongside it.
+type FileSink struct {
+	mu         sync.Mutex
+	path       string
+	maxBytes   int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// NewFileSink opens (or creates) path for appending and prepares it for rotation.
+func NewFileSink(path string, maxBytes int64, maxBackups int) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &FileSink{path: path, maxBytes: maxBytes, maxBackups: maxBackups, file: file, size: info.Size()}, nil
+}
+
+func (s *FileSink) WriteLog(entry LogEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	line := fmt.Sprintf("[%s] %s %s\n", entry.At.Format(time.RFC3339), entry.Level, entry.Message)
+	if s.size+int64(len(line)) > s.maxBytes {
+		s.rotate()
+	}
+	n, _ := s.file.WriteString(line)
+	s.size += int64(n)
+}
+
+func (s *FileSink) rotate() {
+	s.file.Close()
+	for i := s.maxBackups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+	}
+	os.Rename(s.path, s.path+".1")
+
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	s.file = file
+	s.size = 0
+}
+
+// transportLogSink streams log entries to the keymetrics UI over the "logs" channel.
+type transportLogSink struct {
+	transporter *Transporter
+}
+
+func (s transportLogSink) WriteLog(entry LogEntry) {
+	s.transporter.Send("logs", entry)
+}
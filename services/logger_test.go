@@ -0,0 +1,116 @@
+package services
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingSink struct {
+	mu      sync.Mutex
+	entries []LogEntry
+}
+
+func (s *recordingSink) WriteLog(entry LogEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, entry)
+}
+
+func (s *recordingSink) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+func TestLoggerFiltersBelowMinLevel(t *testing.T) {
+	l := NewLogger()
+	l.Start()
+	l.SetLevel(LogWarn)
+
+	sink := &recordingSink{}
+	l.AddSink(sink)
+
+	l.Info("ignored")
+	l.Debug("ignored")
+	l.Warn("kept")
+
+	if got := sink.count(); got != 1 {
+		t.Fatalf("sink received %d entries, want 1 (only >= LogWarn)", got)
+	}
+}
+
+func TestLoggerDropsWhenDisabled(t *testing.T) {
+	l := NewLogger()
+	l.SetLevel(LogTrace)
+	sink := &recordingSink{}
+	l.AddSink(sink)
+
+	l.Info("not started yet")
+
+	if got := sink.count(); got != 0 {
+		t.Fatalf("sink received %d entries, want 0 (logger not Start()ed)", got)
+	}
+	if got := len(l.Flush()); got != 0 {
+		t.Fatalf("Flush() returned %d entries, want 0", got)
+	}
+}
+
+func TestLoggerRateLimit(t *testing.T) {
+	l := NewLogger()
+	l.Start()
+	l.SetLevel(LogTrace)
+	l.RateLimit(LogInfo, 2, time.Minute)
+
+	sink := &recordingSink{}
+	l.AddSink(sink)
+
+	for i := 0; i < 5; i++ {
+		l.Info("spam")
+	}
+
+	if got := sink.count(); got != 2 {
+		t.Fatalf("sink received %d entries, want 2 (rate limited to 2/min)", got)
+	}
+}
+
+func TestLoggerFlushDrainsBuffer(t *testing.T) {
+	l := NewLogger()
+	l.Start()
+	l.SetLevel(LogTrace)
+
+	l.Info("one")
+	l.Info("two")
+
+	entries := l.Flush()
+	if len(entries) != 2 {
+		t.Fatalf("Flush() returned %d entries, want 2", len(entries))
+	}
+	if len(l.Flush()) != 0 {
+		t.Fatal("second Flush() should return no entries once drained")
+	}
+}
+
+// TestLoggerStartClearsStaleBufferSoHistoryIsntReplayed reproduces the
+// dashboard-reopen scenario: an entry delivered live during one enabled
+// session must not reappear in Flush()'s history after Stop/Start again.
+func TestLoggerStartClearsStaleBufferSoHistoryIsntReplayed(t *testing.T) {
+	l := NewLogger()
+	l.SetLevel(LogTrace)
+	sink := &recordingSink{}
+	l.AddSink(sink)
+
+	l.Start()
+	l.Info("delivered live")
+	if got := sink.count(); got != 1 {
+		t.Fatalf("sink received %d entries, want 1", got)
+	}
+
+	l.Stop()
+	l.Start()
+
+	replayed := l.Flush()
+	if len(replayed) != 0 {
+		t.Fatalf("Flush() after restart replayed %d entries, want 0 (already delivered live)", len(replayed))
+	}
+}
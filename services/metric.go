@@ -1,18 +1,315 @@
 package services
 
-import "github.com/keymetrics/pm2-io-apm-go/structures"
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
-var metrics []*structures.Metric
+	"github.com/keymetrics/pm2-io-apm-go/structures"
+)
 
+// registryEntry pairs a metric with the mutex that guards writes to its
+// Value field. The mutex is shared with whatever Counter/Gauge/Histogram/
+// Meter owns the metric, so GetMetricsAsMap's read of Value can never race
+// with the owner's write - Value's concrete type varies by metric kind, so
+// an unsynchronized read/write pair would be a race on the interface word
+// itself, not just the underlying number.
+type registryEntry struct {
+	metric *structures.Metric
+	mu     *sync.Mutex
+}
+
+// MetricRegistry is a concurrency-safe collection of structures.Metric keyed by
+// their fully-qualified (label-flattened) name. It replaces the old package-level
+// metrics slice, which allowed concurrent appends to race and had no way to look
+// a metric back up or remove it.
+type MetricRegistry struct {
+	mu      sync.RWMutex
+	metrics map[string]registryEntry
+}
+
+// NewMetricRegistry returns an empty registry.
+func NewMetricRegistry() *MetricRegistry {
+	return &MetricRegistry{metrics: make(map[string]registryEntry)}
+}
+
+var defaultRegistry = NewMetricRegistry()
+
+// Register adds metric to the registry, replacing any existing metric with the
+// same name, and returns the mutex that must be held while writing metric.Value.
+func (r *MetricRegistry) Register(metric *structures.Metric) *sync.Mutex {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	valueMu := &sync.Mutex{}
+	r.metrics[metric.Name] = registryEntry{metric: metric, mu: valueMu}
+	return valueMu
+}
+
+// Unregister removes the metric with the given name, if any.
+func (r *MetricRegistry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.metrics, name)
+}
+
+// Get looks a metric up by its fully-qualified name.
+func (r *MetricRegistry) Get(name string) (*structures.Metric, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entry, ok := r.metrics[name]
+	return entry.metric, ok
+}
+
+// Range calls fn for every registered metric, stopping early if fn returns false.
+// fn is called outside the registry lock so it may safely register/unregister metrics.
+func (r *MetricRegistry) Range(fn func(metric *structures.Metric) bool) {
+	r.mu.RLock()
+	snapshot := make([]*structures.Metric, 0, len(r.metrics))
+	for _, entry := range r.metrics {
+		snapshot = append(snapshot, entry.metric)
+	}
+	r.mu.RUnlock()
+
+	for _, metric := range snapshot {
+		if !fn(metric) {
+			return
+		}
+	}
+}
+
+// GetMetricsAsMap refreshes and returns every registered metric, keyed by name.
+// Each metric is read under its own value mutex, so it can never observe a
+// Value field half-written by a concurrent Inc/Set/Observe/Mark.
+func (r *MetricRegistry) GetMetricsAsMap() map[string]*structures.Metric {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	m := make(map[string]*structures.Metric, len(r.metrics))
+	for name, entry := range r.metrics {
+		entry.mu.Lock()
+		entry.metric.Get()
+		m[name] = entry.metric
+		entry.mu.Unlock()
+	}
+	return m
+}
+
+// AddMetric registers metric on the default registry. Kept for backward
+// compatibility with callers written against the old package-level API.
 func AddMetric(metric *structures.Metric) {
-	metrics = append(metrics, metric)
+	defaultRegistry.Register(metric)
 }
 
+// GetMetricsAsMap refreshes and returns every metric on the default registry.
 func GetMetricsAsMap() map[string]*structures.Metric {
-	m := make(map[string]*structures.Metric, len(metrics))
-	for _, metric := range metrics {
-		metric.Get()
-		m[metric.Name] = metric
+	return defaultRegistry.GetMetricsAsMap()
+}
+
+// WithLabels flattens tags into the Prometheus-style name Keymetrics expects,
+// e.g. WithLabels("http_requests_total", map[string]string{"method": "GET"})
+// returns `http_requests_total{method="GET"}`. Labels are sorted by key so the
+// same tag set always produces the same name.
+func WithLabels(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
 	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", k, labels[k]))
+	}
+	return fmt.Sprintf("%s{%s}", name, strings.Join(pairs, ","))
+}
+
+// Counter is a monotonically increasing metric, e.g. a request count.
+type Counter struct {
+	metric  *structures.Metric
+	valueMu *sync.Mutex
+	value   int64
+}
+
+// NewCounter registers and returns a new Counter on the default registry.
+func NewCounter(name string, labels map[string]string) *Counter {
+	metric := &structures.Metric{Name: WithLabels(name, labels), Value: int64(0)}
+	valueMu := defaultRegistry.Register(metric)
+	return &Counter{metric: metric, valueMu: valueMu}
+}
+
+func (c *Counter) Inc() { c.Add(1) }
+func (c *Counter) Add(delta int64) {
+	v := atomic.AddInt64(&c.value, delta)
+	c.valueMu.Lock()
+	c.metric.Value = v
+	c.valueMu.Unlock()
+}
+func (c *Counter) Value() int64               { return atomic.LoadInt64(&c.value) }
+func (c *Counter) Metric() *structures.Metric { return c.metric }
+
+// Gauge is a metric that can go up or down, e.g. an in-flight request count.
+type Gauge struct {
+	metric  *structures.Metric
+	valueMu *sync.Mutex
+	value   int64 // stored as math.Float64bits
+}
+
+// NewGauge registers and returns a new Gauge on the default registry.
+func NewGauge(name string, labels map[string]string) *Gauge {
+	metric := &structures.Metric{Name: WithLabels(name, labels), Value: float64(0)}
+	valueMu := defaultRegistry.Register(metric)
+	return &Gauge{metric: metric, valueMu: valueMu}
+}
+
+func (g *Gauge) Set(value float64) {
+	atomic.StoreInt64(&g.value, int64(math.Float64bits(value)))
+	g.valueMu.Lock()
+	g.metric.Value = value
+	g.valueMu.Unlock()
+}
+
+func (g *Gauge) Inc() { g.Add(1) }
+func (g *Gauge) Dec() { g.Add(-1) }
+
+func (g *Gauge) Add(delta float64) {
+	for {
+		old := atomic.LoadInt64(&g.value)
+		next := math.Float64frombits(uint64(old)) + delta
+		if atomic.CompareAndSwapInt64(&g.value, old, int64(math.Float64bits(next))) {
+			g.valueMu.Lock()
+			g.metric.Value = next
+			g.valueMu.Unlock()
+			return
+		}
+	}
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(uint64(atomic.LoadInt64(&g.value)))
+}
+
+func (g *Gauge) Metric() *structures.Metric { return g.metric }
+
+// Histogram buckets observations into the given upper bounds (e.g. latency
+// buckets in milliseconds) and reports per-bucket counts plus the total count
+// and sum, matching what Keymetrics renders for histogram metrics.
+type Histogram struct {
+	mu      *sync.Mutex
+	metric  *structures.Metric
+	bounds  []float64
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// NewHistogram registers and returns a new Histogram with the given bucket
+// upper bounds. Bounds are sorted ascending; a final +Inf bucket is implicit.
+func NewHistogram(name string, labels map[string]string, bounds []float64) *Histogram {
+	sorted := append([]float64(nil), bounds...)
+	sort.Float64s(sorted)
+
+	metric := &structures.Metric{Name: WithLabels(name, labels)}
+	valueMu := defaultRegistry.Register(metric)
+	h := &Histogram{
+		mu:      valueMu,
+		metric:  metric,
+		bounds:  sorted,
+		buckets: make([]uint64, len(sorted)+1),
+	}
+	h.mu.Lock()
+	h.refresh()
+	h.mu.Unlock()
+	return h
+}
+
+// Observe records value into the matching bucket.
+func (h *Histogram) Observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	idx := sort.SearchFloat64s(h.bounds, value)
+	h.buckets[idx]++
+	h.count++
+	h.sum += value
+	h.refresh()
+}
+
+// refresh must be called with mu held; it snapshots the histogram into the
+// Metric's Value as a map for serialization.
+func (h *Histogram) refresh() {
+	snapshot := make(map[string]uint64, len(h.buckets))
+	for i, bound := range h.bounds {
+		snapshot[fmt.Sprintf("le_%g", bound)] = h.buckets[i]
+	}
+	snapshot["le_+Inf"] = h.buckets[len(h.buckets)-1]
+
+	h.metric.Value = map[string]interface{}{
+		"buckets": snapshot,
+		"count":   h.count,
+		"sum":     h.sum,
+	}
+}
+
+func (h *Histogram) Metric() *structures.Metric { return h.metric }
+
+// Meter tracks a moving-average rate of events per second, refreshed on every
+// Mark so the registered Metric always reflects the current rate.
+type Meter struct {
+	mu       *sync.Mutex
+	metric   *structures.Metric
+	count    int64
+	rate     float64
+	start    time.Time
+	lastMark time.Time
+	// alpha is the EWMA smoothing factor, applied once per Mark. Its value is
+	// the one-minute factor for a 5-second tick, so decay follows the number
+	// of Marks, not elapsed time.
+	alpha float64
+}
+
+// NewMeter registers and returns a new Meter on the default registry.
+func NewMeter(name string, labels map[string]string) *Meter {
+	metric := &structures.Metric{Name: WithLabels(name, labels), Value: float64(0)}
+	valueMu := defaultRegistry.Register(metric)
+	now := time.Now()
+	m := &Meter{mu: valueMu, metric: metric, start: now, lastMark: now, alpha: 1 - math.Exp(-5.0/60.0)}
 	return m
 }
+
+// Mark records n events and updates the exponentially weighted moving average rate.
+func (m *Meter) Mark(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(m.lastMark).Seconds()
+	m.lastMark = now
+	m.count += n
+
+	if elapsed <= 0 {
+		return
+	}
+	instantRate := float64(n) / elapsed
+	m.rate += m.alpha * (instantRate - m.rate)
+	m.metric.Value = m.rate
+}
+
+func (m *Meter) Rate() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.rate
+}
+
+func (m *Meter) Count() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.count
+}
+
+func (m *Meter) Metric() *structures.Metric { return m.metric }
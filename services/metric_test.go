@@ -0,0 +1,60 @@
+package services
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/keymetrics/pm2-io-apm-go/structures"
+)
+
+// TestCounterConcurrentAddRacesWithGetMetricsAsMap exercises the path the
+// race detector would catch if metric.Value writes and GetMetricsAsMap's
+// read weren't both guarded by the same per-metric mutex: many goroutines
+// incrementing a Counter while another goroutine repeatedly reads it back
+// out of the registry.
+func TestCounterConcurrentAddRacesWithGetMetricsAsMap(t *testing.T) {
+	c := NewCounter("test_counter_concurrent", nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Inc()
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				GetMetricsAsMap()
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(done)
+
+	if got := c.Value(); got != 50 {
+		t.Errorf("Value() = %d, want 50", got)
+	}
+}
+
+func TestMetricRegistryGetAndUnregister(t *testing.T) {
+	r := NewMetricRegistry()
+	metric := &structures.Metric{Name: "registry_test", Value: int64(0)}
+
+	r.Register(metric)
+	if got, ok := r.Get(metric.Name); !ok || got != metric {
+		t.Fatalf("Get(%q) = (%v, %v), want (%v, true)", metric.Name, got, ok, metric)
+	}
+
+	r.Unregister(metric.Name)
+	if _, ok := r.Get(metric.Name); ok {
+		t.Fatalf("Get(%q) found a metric after Unregister", metric.Name)
+	}
+}
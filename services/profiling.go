@@ -0,0 +1,241 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"sync"
+	"time"
+)
+
+const (
+	// maxCPUProfileDuration caps how long a CPU or trace capture may run, so a
+	// forgotten stop can't pin the process in profiling mode indefinitely.
+	maxCPUProfileDuration = 5 * time.Minute
+	// maxProfileBytes caps how much profile data is kept/streamed per capture.
+	maxProfileBytes = 20 * 1024 * 1024
+	// profileChunkSize is the size of each base64 chunk sent on the
+	// "profilings" channel, keeping individual WS frames small.
+	profileChunkSize = 32 * 1024
+)
+
+// ProfileKind identifies which runtime profile is being captured.
+type ProfileKind string
+
+const (
+	ProfileCPU       ProfileKind = "cpu"
+	ProfileHeap      ProfileKind = "heap"
+	ProfileGoroutine ProfileKind = "goroutine"
+	ProfileTrace     ProfileKind = "trace"
+)
+
+// Profiler tracks which ProfileKinds are currently running, so two profiles
+// of the same kind can never overlap.
+type Profiler struct {
+	mu      sync.Mutex
+	running map[ProfileKind]bool
+}
+
+func NewProfiler() *Profiler {
+	return &Profiler{running: make(map[ProfileKind]bool)}
+}
+
+func (p *Profiler) tryStart(kind ProfileKind) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.running[kind] {
+		return false
+	}
+	p.running[kind] = true
+	return true
+}
+
+func (p *Profiler) finish(kind ProfileKind) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.running, kind)
+}
+
+func capBytes(data []byte) []byte {
+	if len(data) > maxProfileBytes {
+		return data[:maxProfileBytes]
+	}
+	return data
+}
+
+// streamProfile base64-encodes data and streams it to the dashboard over the
+// "profilings" channel in fixed-size chunks, so a large profile doesn't have
+// to fit in a single WS frame.
+func (transporter *Transporter) streamProfile(kind ProfileKind, data []byte, captureErr error) {
+	if captureErr != nil {
+		transporter.Send("profilings", map[string]interface{}{
+			"kind":  string(kind),
+			"error": captureErr.Error(),
+		})
+		return
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(data)
+	total := (len(encoded) + profileChunkSize - 1) / profileChunkSize
+	if total == 0 {
+		total = 1
+		encoded = ""
+	}
+
+	for i := 0; i < total; i++ {
+		start := i * profileChunkSize
+		end := start + profileChunkSize
+		if end > len(encoded) {
+			end = len(encoded)
+		}
+		transporter.Send("profilings", map[string]interface{}{
+			"kind":  string(kind),
+			"chunk": i,
+			"total": total,
+			"data":  encoded[start:end],
+			"done":  i == total-1,
+		})
+	}
+}
+
+func durationFromPayload(payload map[string]interface{}) time.Duration {
+	ms, ok := payload["duration"].(float64)
+	if !ok || ms <= 0 {
+		return 0
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// StartCPUProfiling begins a CPU profile and streams it over "profilings" once the duration elapses or
+// StopCPUProfiling is called early - whichever happens first. A duration over
+// maxCPUProfileDuration is rejected with an error; zero or less runs for
+// maxCPUProfileDuration.
+func (transporter *Transporter) StartCPUProfiling(duration time.Duration) error {
+	if duration > maxCPUProfileDuration {
+		return fmt.Errorf("cpu profile duration %s exceeds the %s max", duration, maxCPUProfileDuration)
+	}
+	if duration <= 0 {
+		duration = maxCPUProfileDuration
+	}
+
+	if !transporter.Profiler.tryStart(ProfileCPU) {
+		return fmt.Errorf("cpu profiling is already running")
+	}
+
+	buf := &bytes.Buffer{}
+	if err := pprof.StartCPUProfile(buf); err != nil {
+		transporter.Profiler.finish(ProfileCPU)
+		return err
+	}
+
+	transporter.mu.Lock()
+	transporter.cpuProfileBuf = buf
+	transporter.cpuProfileTimer = time.AfterFunc(duration, transporter.StopCPUProfiling)
+	transporter.mu.Unlock()
+
+	return nil
+}
+
+// StopCPUProfiling stops an in-progress CPU profile and streams its result.
+// Safe to call even if no profile is running, or more than once. Cancels the
+// pending auto-stop timer so a profile stopped early doesn't leave a stale
+// timer around to kill a later profiling session started within the same
+// duration window.
+func (transporter *Transporter) StopCPUProfiling() {
+	transporter.mu.Lock()
+	buf := transporter.cpuProfileBuf
+	transporter.cpuProfileBuf = nil
+	if transporter.cpuProfileTimer != nil {
+		transporter.cpuProfileTimer.Stop()
+		transporter.cpuProfileTimer = nil
+	}
+	transporter.mu.Unlock()
+	if buf == nil {
+		return
+	}
+
+	pprof.StopCPUProfile()
+	transporter.Profiler.finish(ProfileCPU)
+	transporter.streamProfile(ProfileCPU, capBytes(buf.Bytes()), nil)
+}
+
+// StartTraceProfiling begins an execution trace and streams it over
+// "profilings" once the duration elapses or StopTraceProfiling is called
+// early. A duration over maxCPUProfileDuration is rejected with an error;
+// zero or less runs for maxCPUProfileDuration.
+func (transporter *Transporter) StartTraceProfiling(duration time.Duration) error {
+	if duration > maxCPUProfileDuration {
+		return fmt.Errorf("trace duration %s exceeds the %s max", duration, maxCPUProfileDuration)
+	}
+	if duration <= 0 {
+		duration = maxCPUProfileDuration
+	}
+
+	if !transporter.Profiler.tryStart(ProfileTrace) {
+		return fmt.Errorf("trace profiling is already running")
+	}
+
+	buf := &bytes.Buffer{}
+	if err := trace.Start(buf); err != nil {
+		transporter.Profiler.finish(ProfileTrace)
+		return err
+	}
+
+	transporter.mu.Lock()
+	transporter.traceProfileBuf = buf
+	transporter.traceProfileTimer = time.AfterFunc(duration, transporter.StopTraceProfiling)
+	transporter.mu.Unlock()
+
+	return nil
+}
+
+// StopTraceProfiling stops an in-progress trace and streams its result. Safe
+// to call even if no trace is running, or more than once. Cancels the
+// pending auto-stop timer so a trace stopped early doesn't leave a stale
+// timer around to kill a later trace session started within the same
+// duration window.
+func (transporter *Transporter) StopTraceProfiling() {
+	transporter.mu.Lock()
+	buf := transporter.traceProfileBuf
+	transporter.traceProfileBuf = nil
+	if transporter.traceProfileTimer != nil {
+		transporter.traceProfileTimer.Stop()
+		transporter.traceProfileTimer = nil
+	}
+	transporter.mu.Unlock()
+	if buf == nil {
+		return
+	}
+
+	trace.Stop()
+	transporter.Profiler.finish(ProfileTrace)
+	transporter.streamProfile(ProfileTrace, capBytes(buf.Bytes()), nil)
+}
+
+// CaptureHeapDump takes a single heap profile snapshot and streams it.
+func (transporter *Transporter) CaptureHeapDump() {
+	if !transporter.Profiler.tryStart(ProfileHeap) {
+		return
+	}
+	defer transporter.Profiler.finish(ProfileHeap)
+
+	var buf bytes.Buffer
+	runtime.GC()
+	err := pprof.WriteHeapProfile(&buf)
+	transporter.streamProfile(ProfileHeap, capBytes(buf.Bytes()), err)
+}
+
+// CaptureGoroutineDump takes a single goroutine profile snapshot and streams it.
+func (transporter *Transporter) CaptureGoroutineDump() {
+	if !transporter.Profiler.tryStart(ProfileGoroutine) {
+		return
+	}
+	defer transporter.Profiler.finish(ProfileGoroutine)
+
+	var buf bytes.Buffer
+	err := pprof.Lookup("goroutine").WriteTo(&buf, 2)
+	transporter.streamProfile(ProfileGoroutine, capBytes(buf.Bytes()), err)
+}
@@ -0,0 +1,71 @@
+package services
+
+import "sync"
+
+// OverflowPolicy controls what an OutboundQueue does when Push is called while full.
+type OverflowPolicy int
+
+const (
+	// DropOldest discards the oldest queued message to make room for the new one.
+	DropOldest OverflowPolicy = iota
+	// DropNewest discards the incoming message, keeping the queue as-is.
+	DropNewest
+	// Block waits until space frees up via Drain.
+	Block
+)
+
+// OutboundQueue buffers outbound websocket payloads while the Transporter is
+// disconnected, so Send/SendJson calls aren't silently lost, and flushes them
+// in order once the connection is re-established.
+type OutboundQueue struct {
+	mu       sync.Mutex
+	notFull  *sync.Cond
+	items    [][]byte
+	capacity int
+	policy   OverflowPolicy
+}
+
+// NewOutboundQueue returns a queue holding at most capacity messages, applying
+// policy once it's full.
+func NewOutboundQueue(capacity int, policy OverflowPolicy) *OutboundQueue {
+	q := &OutboundQueue{capacity: capacity, policy: policy}
+	q.notFull = sync.NewCond(&q.mu)
+	return q
+}
+
+// Push enqueues item, applying the overflow policy if the queue is full.
+// It reports whether item was kept.
+func (q *OutboundQueue) Push(item []byte) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.items) >= q.capacity {
+		switch q.policy {
+		case DropOldest:
+			q.items = q.items[1:]
+		case DropNewest:
+			return false
+		case Block:
+			q.notFull.Wait()
+		}
+	}
+	q.items = append(q.items, item)
+	return true
+}
+
+// Drain removes and returns every queued item, in order.
+func (q *OutboundQueue) Drain() [][]byte {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	items := q.items
+	q.items = nil
+	q.notFull.Broadcast()
+	return items
+}
+
+// Len reports how many messages are currently queued.
+func (q *OutboundQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOutboundQueueDrainOrder(t *testing.T) {
+	q := NewOutboundQueue(10, DropOldest)
+	for _, item := range [][]byte{[]byte("a"), []byte("b"), []byte("c")} {
+		if !q.Push(item) {
+			t.Fatalf("Push(%s) = false, want true", item)
+		}
+	}
+	if n := q.Len(); n != 3 {
+		t.Fatalf("Len() = %d, want 3", n)
+	}
+
+	drained := q.Drain()
+	want := []string{"a", "b", "c"}
+	if len(drained) != len(want) {
+		t.Fatalf("Drain() returned %d items, want %d", len(drained), len(want))
+	}
+	for i, item := range drained {
+		if string(item) != want[i] {
+			t.Errorf("Drain()[%d] = %q, want %q", i, item, want[i])
+		}
+	}
+	if n := q.Len(); n != 0 {
+		t.Fatalf("Len() after Drain() = %d, want 0", n)
+	}
+}
+
+func TestOutboundQueueDropOldest(t *testing.T) {
+	q := NewOutboundQueue(2, DropOldest)
+	q.Push([]byte("a"))
+	q.Push([]byte("b"))
+	if ok := q.Push([]byte("c")); !ok {
+		t.Fatalf("Push(c) = false, want true (DropOldest keeps the new item)")
+	}
+
+	drained := q.Drain()
+	want := []string{"b", "c"}
+	if len(drained) != len(want) {
+		t.Fatalf("Drain() = %v, want %v", drained, want)
+	}
+	for i, item := range drained {
+		if string(item) != want[i] {
+			t.Errorf("Drain()[%d] = %q, want %q", i, item, want[i])
+		}
+	}
+}
+
+func TestOutboundQueueDropNewest(t *testing.T) {
+	q := NewOutboundQueue(2, DropNewest)
+	q.Push([]byte("a"))
+	q.Push([]byte("b"))
+	if ok := q.Push([]byte("c")); ok {
+		t.Fatalf("Push(c) = true, want false (DropNewest discards the incoming item)")
+	}
+
+	drained := q.Drain()
+	want := []string{"a", "b"}
+	if len(drained) != len(want) {
+		t.Fatalf("Drain() = %v, want %v", drained, want)
+	}
+	for i, item := range drained {
+		if string(item) != want[i] {
+			t.Errorf("Drain()[%d] = %q, want %q", i, item, want[i])
+		}
+	}
+}
+
+func TestOutboundQueueBlockUnblocksOnDrain(t *testing.T) {
+	q := NewOutboundQueue(1, Block)
+	q.Push([]byte("a"))
+
+	pushed := make(chan bool, 1)
+	go func() {
+		pushed <- q.Push([]byte("b"))
+	}()
+
+	select {
+	case <-pushed:
+		t.Fatal("Push() returned before Drain() freed capacity")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	q.Drain()
+
+	select {
+	case ok := <-pushed:
+		if !ok {
+			t.Fatal("Push() = false, want true once capacity freed up")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Push() never returned after Drain() freed capacity")
+	}
+}
+
+func TestOutboundQueueConcurrentPush(t *testing.T) {
+	q := NewOutboundQueue(1000, DropOldest)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.Push([]byte("x"))
+		}()
+	}
+	wg.Wait()
+
+	if n := q.Len(); n != 100 {
+		t.Fatalf("Len() = %d, want 100", n)
+	}
+}
@@ -2,12 +2,15 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"net/http"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -15,6 +18,42 @@ import (
 	"github.com/keymetrics/pm2-io-apm-go/structures"
 )
 
+// State describes the Transporter's connection lifecycle.
+type State int32
+
+const (
+	StateDisconnected State = iota
+	StateConnecting
+	StateConnected
+	StateClosed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	minBackoff           = 1 * time.Second
+	maxBackoff           = 60 * time.Second
+	defaultQueueCapacity = 1000
+)
+
+const (
+	handlerStopped int32 = iota
+	handlerRunning
+)
+
 type Transporter struct {
 	Config     *structures.Config
 	Version    string
@@ -24,13 +63,29 @@ type Transporter struct {
 
 	ws              *websocket.Conn
 	mu              sync.Mutex
-	isConnected     bool
-	isHandling      bool
-	isConnecting    bool
-	isClosed        bool
+	state           int32 // atomic State
+	handling        int32 // atomic, guards against starting MessagesHandler twice
 	wsNode          *string
 	heartbeatTicker *time.Ticker // 5 seconds
 	serverTicker    *time.Ticker // 10 minutes
+
+	backoffMu sync.Mutex
+	backoff   time.Duration
+
+	outbound *OutboundQueue
+
+	stateListenersMu sync.Mutex
+	stateListeners   []func(State)
+
+	Logger         *Logger
+	loggerWired    bool
+	loggerWiredMux sync.Mutex
+
+	Profiler          *Profiler
+	cpuProfileBuf     *bytes.Buffer
+	cpuProfileTimer   *time.Timer
+	traceProfileBuf   *bytes.Buffer
+	traceProfileTimer *time.Timer
 }
 
 type Message struct {
@@ -39,18 +94,109 @@ type Message struct {
 }
 
 func NewTransporter(config *structures.Config, version string, hostname string, serverName string, node string) *Transporter {
-	return &Transporter{
+	transporter := &Transporter{
 		Config:     config,
 		Version:    version,
 		Hostname:   hostname,
 		ServerName: serverName,
 		Node:       node,
 
-		isHandling:   false,
-		isConnecting: false,
-		isClosed:     false,
-		isConnected:  false,
+		outbound: NewOutboundQueue(defaultQueueCapacity, DropOldest),
+		Logger:   NewLogger(),
+		Profiler: NewProfiler(),
+	}
+	transporter.registerProfilingActions()
+	return transporter
+}
+
+// registerProfilingActions exposes the profiling capabilities as regular
+// actions (in addition to the trigger:pm2:action wiring in MessagesHandler) so they show
+// up in the dashboard's custom actions list like any user-defined action.
+func (transporter *Transporter) registerProfilingActions() {
+	AddAction(&structures.Action{
+		ActionName: "Start CPU profiling",
+		TypedCallback: func(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+			if err := transporter.StartCPUProfiling(durationFromPayload(params)); err != nil {
+				return nil, err
+			}
+			return "cpu profiling started", nil
+		},
+	})
+	AddAction(&structures.Action{
+		ActionName: "Stop CPU profiling",
+		TypedCallback: func(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+			transporter.StopCPUProfiling()
+			return "cpu profiling stopped", nil
+		},
+	})
+	AddAction(&structures.Action{
+		ActionName: "Heap dump",
+		TypedCallback: func(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+			transporter.CaptureHeapDump()
+			return "heap dump captured", nil
+		},
+	})
+	AddAction(&structures.Action{
+		ActionName: "Goroutine dump",
+		TypedCallback: func(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+			transporter.CaptureGoroutineDump()
+			return "goroutine dump captured", nil
+		},
+	})
+	AddAction(&structures.Action{
+		ActionName: "Trace profile",
+		TypedCallback: func(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+			if err := transporter.StartTraceProfiling(durationFromPayload(params)); err != nil {
+				return nil, err
+			}
+			return "trace profiling started", nil
+		},
+	})
+}
+
+// State reports the Transporter's current connection state.
+func (transporter *Transporter) State() State {
+	return State(atomic.LoadInt32(&transporter.state))
+}
+
+// OnStateChange registers fn to be called whenever the connection state
+// changes. fn is called synchronously from whichever goroutine triggered the
+// transition, so it should not block.
+func (transporter *Transporter) OnStateChange(fn func(State)) {
+	transporter.stateListenersMu.Lock()
+	defer transporter.stateListenersMu.Unlock()
+	transporter.stateListeners = append(transporter.stateListeners, fn)
+}
+
+// SetOutboundQueue reconfigures the queue used to buffer Send/SendJson calls
+// made while disconnected. Call it before Connect; it is not safe to resize a
+// queue that's actively being flushed.
+func (transporter *Transporter) SetOutboundQueue(capacity int, policy OverflowPolicy) {
+	transporter.outbound = NewOutboundQueue(capacity, policy)
+}
+
+func (transporter *Transporter) setState(state State) {
+	atomic.StoreInt32(&transporter.state, int32(state))
+
+	transporter.stateListenersMu.Lock()
+	listeners := append([]func(State){}, transporter.stateListeners...)
+	transporter.stateListenersMu.Unlock()
+
+	for _, listener := range listeners {
+		listener(state)
+	}
+}
+
+// wireLogger attaches the WS transport as a log sink the first time logging is
+// started, so repeated startLogging calls don't register duplicate sinks.
+func (transporter *Transporter) wireLogger() {
+	transporter.loggerWiredMux.Lock()
+	defer transporter.loggerWiredMux.Unlock()
+	if transporter.loggerWired {
+		return
 	}
+	transporter.Logger.AddSink(transportLogSink{transporter: transporter})
+	transporter.loggerWired = true
 }
 
 func (transporter *Transporter) GetServer() *string {
@@ -84,14 +230,13 @@ func (transporter *Transporter) GetServer() *string {
 }
 
 func (transporter *Transporter) Connect() {
+	transporter.setState(StateConnecting)
+
 	if transporter.wsNode == nil {
 		transporter.wsNode = transporter.GetServer()
 	}
 	if transporter.wsNode == nil {
-		go func() {
-			time.Sleep(10 * time.Second)
-			transporter.Connect()
-		}()
+		transporter.scheduleReconnect()
 		return
 	}
 
@@ -104,22 +249,26 @@ func (transporter *Transporter) Connect() {
 
 	c, _, err := websocket.DefaultDialer.Dial(*transporter.wsNode, headers)
 	if err != nil {
-		time.Sleep(2 * time.Second)
-		transporter.isConnecting = false
-		transporter.CloseAndReconnect()
+		transporter.scheduleReconnect()
 		return
 	}
 	c.SetCloseHandler(func(code int, text string) error {
-		transporter.isClosed = true
+		transporter.setState(StateDisconnected)
 		return nil
 	})
 
-	transporter.isConnected = true
-	transporter.isConnecting = false
-
+	transporter.mu.Lock()
 	transporter.ws = c
+	transporter.mu.Unlock()
 
-	if !transporter.isHandling {
+	transporter.backoffMu.Lock()
+	transporter.backoff = minBackoff
+	transporter.backoffMu.Unlock()
+
+	transporter.setState(StateConnected)
+	transporter.flushOutbound()
+
+	if atomic.CompareAndSwapInt32(&transporter.handling, handlerStopped, handlerRunning) {
 		transporter.SetHandlers()
 	}
 
@@ -128,20 +277,61 @@ func (transporter *Transporter) Connect() {
 			return
 		}
 		transporter.serverTicker = time.NewTicker(10 * time.Minute)
-		for {
-			<-transporter.serverTicker.C
+		for range transporter.serverTicker.C {
 			srv := transporter.GetServer()
-			if *srv != *transporter.wsNode {
-				transporter.wsNode = srv
-				transporter.CloseAndReconnect()
+			if srv == nil || transporter.wsNode == nil || *srv == *transporter.wsNode {
+				continue
 			}
+			transporter.wsNode = srv
+			transporter.CloseAndReconnect()
 		}
 	}()
 }
 
-func (transporter *Transporter) SetHandlers() {
-	transporter.isHandling = true
+// nextBackoff returns the wait duration to use now and the backoff to store
+// for next time, given the current stored backoff: current <= 0 (never set)
+// starts at minBackoff, otherwise it doubles, capped at maxBackoff.
+func nextBackoff(current time.Duration) (wait time.Duration, next time.Duration) {
+	if current <= 0 {
+		current = minBackoff
+	}
+	wait = current
+	next = current * 2
+	if next > maxBackoff {
+		next = maxBackoff
+	}
+	return wait, next
+}
+
+// jitteredDelay returns a random delay in [wait/2, wait], so many clients
+// backing off at once don't all retry in lockstep.
+func jitteredDelay(wait time.Duration) time.Duration {
+	return wait/2 + time.Duration(rand.Int63n(int64(wait)/2+1))
+}
 
+// scheduleReconnect waits out the current backoff window (exponential, with
+// jitter, capped at maxBackoff) before retrying Connect, then doubles the
+// backoff for next time. A successful Connect resets it back to minBackoff.
+func (transporter *Transporter) scheduleReconnect() {
+	transporter.setState(StateDisconnected)
+
+	transporter.backoffMu.Lock()
+	wait, next := nextBackoff(transporter.backoff)
+	transporter.backoff = next
+	transporter.backoffMu.Unlock()
+
+	delay := jitteredDelay(wait)
+
+	go func() {
+		time.Sleep(delay)
+		if transporter.State() == StateClosed {
+			return
+		}
+		transporter.Connect()
+	}()
+}
+
+func (transporter *Transporter) SetHandlers() {
 	go transporter.MessagesHandler()
 
 	go func() {
@@ -149,10 +339,16 @@ func (transporter *Transporter) SetHandlers() {
 			return
 		}
 		transporter.heartbeatTicker = time.NewTicker(5 * time.Second)
-		for {
-			<-transporter.heartbeatTicker.C
+		for range transporter.heartbeatTicker.C {
 			transporter.mu.Lock()
-			errPinger := transporter.ws.WriteMessage(websocket.PingMessage, []byte{})
+			ws := transporter.ws
+			transporter.mu.Unlock()
+			if ws == nil {
+				continue
+			}
+
+			transporter.mu.Lock()
+			errPinger := ws.WriteMessage(websocket.PingMessage, []byte{})
 			transporter.mu.Unlock()
 			if errPinger != nil {
 				transporter.CloseAndReconnect()
@@ -164,9 +360,17 @@ func (transporter *Transporter) SetHandlers() {
 
 func (transporter *Transporter) MessagesHandler() {
 	for {
-		_, message, err := transporter.ws.ReadMessage()
+		transporter.mu.Lock()
+		ws := transporter.ws
+		transporter.mu.Unlock()
+		if ws == nil {
+			atomic.StoreInt32(&transporter.handling, handlerStopped)
+			return
+		}
+
+		_, message, err := ws.ReadMessage()
 		if err != nil {
-			transporter.isHandling = false
+			atomic.StoreInt32(&transporter.handling, handlerStopped)
 			transporter.CloseAndReconnect()
 			return
 		}
@@ -181,7 +385,16 @@ func (transporter *Transporter) MessagesHandler() {
 			payload := dat["payload"].(map[string]interface{})
 			name := payload["action_name"]
 
-			response := CallAction(name.(string), payload)
+			result := CallAction(name.(string), payload)
+			if result.Err != nil {
+				transporter.Send("trigger:action:failure", map[string]interface{}{
+					"success":     false,
+					"id":          payload["process_id"],
+					"action_name": name,
+					"err":         result.Err.Error(),
+				})
+				continue
+			}
 
 			transporter.Send("trigger:action:success", map[string]interface{}{
 				"success":     true,
@@ -190,7 +403,7 @@ func (transporter *Transporter) MessagesHandler() {
 			})
 			transporter.Send("axm:reply", map[string]interface{}{
 				"action_name": name,
-				"return":      response,
+				"return":      result.Value,
 			})
 
 		} else if dat["channel"] == "trigger:pm2:action" {
@@ -198,14 +411,34 @@ func (transporter *Transporter) MessagesHandler() {
 			name := payload["method_name"]
 			switch name {
 			case "startLogging":
-				transporter.SendJson(map[string]interface{}{
-					"channel": "trigger:pm2:result",
-					"payload": map[string]interface{}{
-						"ret": map[string]interface{}{
-							"err": nil,
-						},
-					},
-				})
+				transporter.wireLogger()
+				transporter.Logger.Start()
+				for _, entry := range transporter.Logger.Flush() {
+					transporter.Send("logs", entry)
+				}
+				transporter.sendPm2Result(nil)
+				break
+			case "stopLogging":
+				transporter.Logger.Stop()
+				transporter.sendPm2Result(nil)
+				break
+			case "startCpuProfiling":
+				transporter.sendPm2Result(transporter.StartCPUProfiling(durationFromPayload(payload)))
+				break
+			case "stopCpuProfiling":
+				transporter.StopCPUProfiling()
+				transporter.sendPm2Result(nil)
+				break
+			case "heapDump":
+				transporter.CaptureHeapDump()
+				transporter.sendPm2Result(nil)
+				break
+			case "goroutineDump":
+				transporter.CaptureGoroutineDump()
+				transporter.sendPm2Result(nil)
+				break
+			case "traceProfile":
+				transporter.sendPm2Result(transporter.StartTraceProfiling(durationFromPayload(payload)))
 				break
 			}
 		} else {
@@ -214,6 +447,26 @@ func (transporter *Transporter) MessagesHandler() {
 	}
 }
 
+// sendPm2Result replies on trigger:pm2:result, the channel the dashboard
+// expects a result on for every trigger:pm2:action it sends.
+func (transporter *Transporter) sendPm2Result(err error) {
+	var errVal interface{}
+	if err != nil {
+		errVal = err.Error()
+	}
+	transporter.SendJson(map[string]interface{}{
+		"channel": "trigger:pm2:result",
+		"payload": map[string]interface{}{
+			"ret": map[string]interface{}{
+				"err": errVal,
+			},
+		},
+	})
+}
+
+// SendJson marshals and sends msg. While disconnected (or if the write
+// fails), it is buffered in the outbound queue instead of being dropped, and
+// flushed once the connection comes back up.
 func (transporter *Transporter) SendJson(msg interface{}) {
 	b, err := json.Marshal(msg)
 	if err != nil {
@@ -221,18 +474,54 @@ func (transporter *Transporter) SendJson(msg interface{}) {
 	}
 
 	transporter.mu.Lock()
-	defer transporter.mu.Unlock()
+	ws := transporter.ws
+	transporter.mu.Unlock()
 
-	if !transporter.isConnected {
+	if transporter.State() != StateConnected || ws == nil {
+		transporter.outbound.Push(b)
 		return
 	}
-	transporter.ws.SetWriteDeadline(time.Now().Add(30 * time.Second))
-	err = transporter.ws.WriteMessage(websocket.TextMessage, b)
+
+	ws.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	transporter.mu.Lock()
+	err = ws.WriteMessage(websocket.TextMessage, b)
+	transporter.mu.Unlock()
 	if err != nil {
+		transporter.outbound.Push(b)
 		transporter.CloseAndReconnect()
 	}
 }
 
+// flushOutbound sends every message buffered while disconnected, in order.
+// It stops (re-queuing what's left) at the first failure so CloseAndReconnect
+// can take over.
+func (transporter *Transporter) flushOutbound() {
+	items := transporter.outbound.Drain()
+	for i, item := range items {
+		transporter.mu.Lock()
+		ws := transporter.ws
+		transporter.mu.Unlock()
+		if ws == nil {
+			for _, rest := range items[i:] {
+				transporter.outbound.Push(rest)
+			}
+			return
+		}
+
+		ws.SetWriteDeadline(time.Now().Add(30 * time.Second))
+		transporter.mu.Lock()
+		err := ws.WriteMessage(websocket.TextMessage, item)
+		transporter.mu.Unlock()
+		if err != nil {
+			for _, rest := range items[i:] {
+				transporter.outbound.Push(rest)
+			}
+			transporter.CloseAndReconnect()
+			return
+		}
+	}
+}
+
 func (transporter *Transporter) Send(channel string, data interface{}) {
 	transporter.SendJson(Message{
 		Channel: channel,
@@ -253,21 +542,46 @@ func (transporter *Transporter) Send(channel string, data interface{}) {
 	})
 }
 
+// CloseAndReconnect tears the current connection down and kicks off the
+// backoff-driven reconnect loop. Safe to call concurrently from the message
+// handler, the heartbeat goroutine, or the server-change ticker.
 func (transporter *Transporter) CloseAndReconnect() {
-	if transporter.isConnecting {
+	if transporter.State() == StateClosed {
 		return
 	}
 
-	if !transporter.isClosed {
-		transporter.ws.Close()
+	transporter.mu.Lock()
+	ws := transporter.ws
+	transporter.mu.Unlock()
+	if ws != nil {
+		ws.Close()
+	}
+
+	transporter.scheduleReconnect()
+}
+
+// Close shuts the connection down for good; no further reconnect attempts
+// are made and OnStateChange listeners receive a final StateClosed event.
+func (transporter *Transporter) Close() {
+	transporter.setState(StateClosed)
+
+	if transporter.heartbeatTicker != nil {
+		transporter.heartbeatTicker.Stop()
+	}
+	if transporter.serverTicker != nil {
+		transporter.serverTicker.Stop()
+	}
+
+	transporter.mu.Lock()
+	ws := transporter.ws
+	transporter.mu.Unlock()
+	if ws != nil {
+		ws.Close()
 	}
-	transporter.isConnected = false
-	transporter.isConnecting = true
-	transporter.Connect()
 }
 
 func (transporter *Transporter) IsConnected() bool {
-	return transporter.isConnected
+	return transporter.State() == StateConnected
 }
 
 func (transporter *Transporter) GetWsNode() *string {
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/keymetrics/pm2-io-apm-go/features/recovery"
 	"github.com/keymetrics/pm2-io-apm-go/services"
 
 	"github.com/keymetrics/pm2-io-apm-go/structures"
@@ -54,12 +55,14 @@ func main() {
 		},
 	})
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	recoverer := recovery.New(Pm2Io.Notifier, 60*time.Second)
+
+	http.Handle("/", recoverer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		for i := 0; i < 1000; i++ {
 			fmt.Fprintf(w, "Hello")
 		}
 		nbreq.Value++
-	})
+	})))
 
 	/*go func() {
 		ticker := time.NewTicker(2 * time.Second)
@@ -83,16 +86,13 @@ func main() {
 		}
 	}()
 
-	/*go func() {
-		ticker := time.NewTicker(6 * time.Second)
-		log.Println("created log ticker")
-		for {
-			<-ticker.C
-			cause := errors.New("Fatal panic error")
-			err := errors.WithStack(cause)
-			Pm2Io.Panic(err)
-		}
-	}()*/
+	// recoverer.Go runs a goroutine the same way Middleware wraps a handler:
+	// it recovers any panic and forwards it to the notifier instead of
+	// crashing the process.
+	recoverer.Go(func() {
+		time.Sleep(6 * time.Second)
+		panic("fatal panic error")
+	})
 
 	http.ListenAndServe(":8080", nil)
 }
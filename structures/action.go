@@ -0,0 +1,32 @@
+package structures
+
+import "context"
+
+// Action is a user-defined action the keymetrics dashboard can trigger (a PM2
+// "custom action"). ActionName is what's shown in the dashboard.
+type Action struct {
+	ActionName string
+
+	// Callback is the legacy, parameter-less form: it takes no arguments and
+	// its returned string is sent back as-is. Kept for backward compatibility
+	// with actions registered before Parameters/TypedCallback existed.
+	Callback func() string
+
+	// Parameters optionally describes the arguments TypedCallback expects, so
+	// the dashboard can render an input form and MessagesHandler can validate
+	// the trigger:action payload before invoking the callback.
+	Parameters []ActionParam
+
+	// TypedCallback is the schema-aware alternative to Callback: it receives
+	// the validated parameters and returns a JSON-serializable value, or an
+	// error that's reported to the dashboard as trigger:action:failure.
+	TypedCallback func(ctx context.Context, params map[string]interface{}) (interface{}, error)
+}
+
+// ActionParam describes one parameter accepted by an Action's TypedCallback.
+type ActionParam struct {
+	Name     string      `json:"name"`
+	Type     string      `json:"type"` // "string", "number", "boolean"
+	Required bool        `json:"required"`
+	Default  interface{} `json:"default,omitempty"`
+}